@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ErrFingerprintMismatch is returned by DoLockedAction when the caller's
+// fingerprint no longer matches the config's current state, meaning
+// something else mutated it first.
+var ErrFingerprintMismatch = errors.New("config fingerprint mismatch")
+
+// Fingerprint returns a SHA-256 hex digest of the config's current JSON
+// representation, for optimistic-concurrency checks on PATCH /config.
+func (c *mailjetConfig) Fingerprint() (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.fingerprintLocked()
+}
+
+func (c *mailjetConfig) fingerprintLocked() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RateLimit returns the configured rate limit for endpoint, if any. Callers
+// on the request path must go through this instead of reading RateLimits
+// directly, since DoLockedAction can reassign it concurrently.
+func (c *mailjetConfig) RateLimit(endpoint string) (rateLimitConfig, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cfg, ok := c.RateLimits[endpoint]
+	return cfg, ok
+}
+
+// RetentionFor returns how many events to keep for apiKey: a per-key
+// override if configured, otherwise the config-wide default (0 = unlimited).
+func (c *mailjetConfig) RetentionFor(apiKey string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if keep, ok := c.Retention[apiKey]; ok {
+		return keep
+	}
+	return c.DefaultRetention
+}
+
+// GetBaseUrl returns the configured Mailjet API base URL.
+func (c *mailjetConfig) GetBaseUrl() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.BaseUrl
+}
+
+// GetMetricsToken returns the bearer token required to read /metrics, if any.
+func (c *mailjetConfig) GetMetricsToken() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.MetricsToken
+}
+
+// GetMaxBodyBytes returns the configured request body size cap.
+func (c *mailjetConfig) GetMaxBodyBytes() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.MaxBodyBytes
+}
+
+// DoLockedAction applies mutate to the config, but only if fingerprint
+// matches the config's current fingerprint (an empty fingerprint skips the
+// check, for internal callers like the config-file watcher). This is the
+// optimistic-concurrency guard against two admin UIs racing a lost update.
+func (c *mailjetConfig) DoLockedAction(fingerprint string, mutate func(*mailjetConfig) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if fingerprint != "" {
+		current, err := c.fingerprintLocked()
+		if err != nil {
+			return err
+		}
+		if fingerprint != current {
+			return ErrFingerprintMismatch
+		}
+	}
+
+	return mutate(c)
+}
+
+// applyPatch sets the value at a JSON Pointer path within the config,
+// validating the result against mailjetConfig's shape before committing it.
+// Callers must hold c.mu (DoLockedAction does this).
+func (c *mailjetConfig) applyPatch(path string, value json.RawMessage) error {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	var generic map[string]interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return err
+	}
+
+	if err := setJSONPointer(generic, path, value); err != nil {
+		return err
+	}
+
+	patched, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+
+	var next mailjetConfig
+	if err := json.Unmarshal(patched, &next); err != nil {
+		return fmt.Errorf("applying patch: %w", err)
+	}
+
+	copyMutableFields(c, &next)
+
+	return nil
+}
+
+// copyMutableFields copies every field of mailjetConfig that can be changed
+// via PATCH /config or a config file reload from src into dst. Both
+// applyPatch and reloadConfigFile go through this so a new field only has to
+// be added here once to hot-reload/patch correctly.
+func copyMutableFields(dst, src *mailjetConfig) {
+	dst.BaseUrl = src.BaseUrl
+	dst.EventStorePath = src.EventStorePath
+	dst.DefaultRetention = src.DefaultRetention
+	dst.Retention = src.Retention
+	dst.RateLimits = src.RateLimits
+	dst.MaxBodyBytes = src.MaxBodyBytes
+	dst.Smtp = src.Smtp
+	dst.MetricsToken = src.MetricsToken
+	dst.Default = src.Default
+}
+
+// setJSONPointer sets the value addressed by a JSON Pointer (RFC 6901) of
+// depth at most two, e.g. "/max_body_bytes" or "/retention/some-api-key".
+func setJSONPointer(doc map[string]interface{}, pointer string, value json.RawMessage) error {
+	if !strings.HasPrefix(pointer, "/") {
+		return fmt.Errorf("invalid JSON pointer: %q", pointer)
+	}
+
+	replacer := strings.NewReplacer("~1", "/", "~0", "~")
+	segments := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = replacer.Replace(segment)
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		return fmt.Errorf("decoding patch value: %w", err)
+	}
+
+	switch len(segments) {
+	case 1:
+		doc[segments[0]] = decoded
+
+	case 2:
+		nested, ok := doc[segments[0]].(map[string]interface{})
+		if !ok {
+			nested = make(map[string]interface{})
+		}
+		nested[segments[1]] = decoded
+		doc[segments[0]] = nested
+
+	default:
+		return fmt.Errorf("unsupported JSON pointer depth: %q", pointer)
+	}
+
+	return nil
+}
+
+// reloadConfigFile re-reads path and applies it to the global config
+// in place, so operators can change retention, base URL or rate limits
+// without restarting the process.
+func reloadConfigFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var next mailjetConfig
+	if err := json.Unmarshal(data, &next); err != nil {
+		return err
+	}
+
+	return config.DoLockedAction("", func(c *mailjetConfig) error {
+		copyMutableFields(c, &next)
+		return nil
+	})
+}
+
+// watchConfig reloads the config file whenever it changes on disk or the
+// process receives SIGHUP, so operators don't need to restart the server
+// to pick up new retention, base URL or rate limit settings.
+func watchConfig(path string) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		ErrorLogger.Println("Unable to start config file watcher", err)
+	} else {
+		defer watcher.Close()
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			ErrorLogger.Println("Unable to watch config directory", err)
+		}
+	}
+
+	for {
+		reload := false
+
+		if watcher == nil {
+			<-sighup
+			reload = true
+		} else {
+			select {
+			case <-sighup:
+				reload = true
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				reload = filepath.Clean(event.Name) == filepath.Clean(path) &&
+					event.Op&(fsnotify.Write|fsnotify.Create) != 0
+			case watchErr, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				ErrorLogger.Println("Config watcher error", watchErr)
+			}
+		}
+
+		if !reload {
+			continue
+		}
+
+		if err := reloadConfigFile(path); err != nil {
+			ErrorLogger.Println("Unable to reload config", err)
+			continue
+		}
+
+		TraceLogger.Println("Config reloaded from", path)
+	}
+}
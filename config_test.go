@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSetJSONPointerTopLevel(t *testing.T) {
+	doc := map[string]interface{}{"base_url": "https://old.example.com"}
+
+	if err := setJSONPointer(doc, "/base_url", json.RawMessage(`"https://new.example.com"`)); err != nil {
+		t.Fatalf("setJSONPointer() error = %v", err)
+	}
+
+	if doc["base_url"] != "https://new.example.com" {
+		t.Fatalf("doc[base_url] = %v, want %q", doc["base_url"], "https://new.example.com")
+	}
+}
+
+func TestSetJSONPointerNestedPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"retention": map[string]interface{}{"existing-key": float64(5)},
+	}
+
+	if err := setJSONPointer(doc, "/retention/new-key", json.RawMessage(`10`)); err != nil {
+		t.Fatalf("setJSONPointer() error = %v", err)
+	}
+
+	retention := doc["retention"].(map[string]interface{})
+	if retention["existing-key"] != float64(5) {
+		t.Fatalf("retention[existing-key] = %v, want 5 (untouched)", retention["existing-key"])
+	}
+	if retention["new-key"] != float64(10) {
+		t.Fatalf("retention[new-key] = %v, want 10", retention["new-key"])
+	}
+}
+
+func TestSetJSONPointerNestedPathCreatesMissingParent(t *testing.T) {
+	doc := map[string]interface{}{}
+
+	if err := setJSONPointer(doc, "/retention/some-api-key", json.RawMessage(`3`)); err != nil {
+		t.Fatalf("setJSONPointer() error = %v", err)
+	}
+
+	retention, ok := doc["retention"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("doc[retention] = %v, want a map", doc["retention"])
+	}
+	if retention["some-api-key"] != float64(3) {
+		t.Fatalf("retention[some-api-key] = %v, want 3", retention["some-api-key"])
+	}
+}
+
+func TestSetJSONPointerEscapesTildeAndSlash(t *testing.T) {
+	doc := map[string]interface{}{}
+
+	if err := setJSONPointer(doc, "/retention/a~1b~0c", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("setJSONPointer() error = %v", err)
+	}
+
+	retention := doc["retention"].(map[string]interface{})
+	if _, ok := retention["a/b~c"]; !ok {
+		t.Fatalf("retention keys = %v, want key %q", retention, "a/b~c")
+	}
+}
+
+func TestSetJSONPointerRejectsMissingLeadingSlash(t *testing.T) {
+	doc := map[string]interface{}{}
+
+	if err := setJSONPointer(doc, "base_url", json.RawMessage(`"x"`)); err == nil {
+		t.Fatal("setJSONPointer() error = nil, want an error for a pointer without a leading slash")
+	}
+}
+
+func TestSetJSONPointerRejectsDepthBeyondTwo(t *testing.T) {
+	doc := map[string]interface{}{}
+
+	if err := setJSONPointer(doc, "/smtp/tls/cert_file", json.RawMessage(`"x"`)); err == nil {
+		t.Fatal("setJSONPointer() error = nil, want an error for a depth-3 pointer")
+	}
+}
+
+func TestDoLockedActionRejectsStaleFingerprint(t *testing.T) {
+	c := &mailjetConfig{BaseUrl: "https://example.com"}
+
+	err := c.DoLockedAction("not-the-real-fingerprint", func(c *mailjetConfig) error {
+		c.BaseUrl = "https://changed.example.com"
+		return nil
+	})
+	if !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("DoLockedAction() error = %v, want ErrFingerprintMismatch", err)
+	}
+	if c.BaseUrl != "https://example.com" {
+		t.Fatalf("BaseUrl = %q, want unchanged", c.BaseUrl)
+	}
+}
+
+func TestDoLockedActionAcceptsMatchingFingerprint(t *testing.T) {
+	c := &mailjetConfig{BaseUrl: "https://example.com"}
+
+	fingerprint, err := c.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() error = %v", err)
+	}
+
+	err = c.DoLockedAction(fingerprint, func(c *mailjetConfig) error {
+		c.BaseUrl = "https://changed.example.com"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DoLockedAction() error = %v", err)
+	}
+	if c.GetBaseUrl() != "https://changed.example.com" {
+		t.Fatalf("BaseUrl = %q, want %q", c.GetBaseUrl(), "https://changed.example.com")
+	}
+}
+
+func TestDoLockedActionEmptyFingerprintSkipsCheck(t *testing.T) {
+	c := &mailjetConfig{BaseUrl: "https://example.com"}
+
+	err := c.DoLockedAction("", func(c *mailjetConfig) error {
+		c.BaseUrl = "https://changed.example.com"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DoLockedAction() error = %v", err)
+	}
+	if c.GetBaseUrl() != "https://changed.example.com" {
+		t.Fatalf("BaseUrl = %q, want %q", c.GetBaseUrl(), "https://changed.example.com")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/kennygrant/sanitize"
+	"github.com/ninefive/mailjet-live-event-dashboard/eventstore"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	// The dashboard is served from the same origin as the API, but allow
+	// cross-origin upgrades so the demo can be embedded elsewhere too.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// replayEvents loads every persisted event for apiKey with an ID greater
+// than since, oldest first, so a reconnecting dashboard can catch up on
+// whatever it missed. A since of 0 replays nothing; fresh subscribers just
+// join the live stream.
+func replayEvents(apiKey string, since int64) ([]eventstore.Event, error) {
+	if since <= 0 {
+		return []eventstore.Event{}, nil
+	}
+
+	return store.Query(apiKey, eventstore.Filter{Since: since, Order: "asc"})
+}
+
+func parseSince(r *http.Request) int64 {
+	since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return since
+}
+
+// handleEventsWS upgrades the connection to a WebSocket and streams events
+// for the given apikey as they are received, replaying anything persisted
+// since the "since" query parameter first.
+func handleEventsWS(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	apiKey := sanitize.BaseName(vars["apikey"])
+	if apiKey == "" {
+		handleError(w, "An API Key must be provided", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		ErrorLogger.Println("Error upgrading to WebSocket", err)
+		return
+	}
+	defer conn.Close()
+
+	// Subscribe before querying the backlog: a subscriber registered after
+	// the query could miss an event published in between. Subscribing
+	// first instead risks the opposite, a live event also appearing in the
+	// backlog, so lastSent drops anything at or below the highest ID
+	// already sent.
+	sub := hub.Subscribe(apiKey)
+	defer hub.Unsubscribe(apiKey, sub)
+
+	since := parseSince(r)
+	backlog, err := replayEvents(apiKey, since)
+	if err != nil {
+		ErrorLogger.Println("Error replaying events", err)
+		return
+	}
+	lastSent := since
+	for _, event := range backlog {
+		if err := conn.WriteJSON(event); err != nil {
+			return
+		}
+		lastSent = event.ID
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.messages:
+			if !ok {
+				return
+			}
+			if event.ID <= lastSent {
+				continue
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+			lastSent = event.ID
+		case <-ticker.C:
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleEventsSSE streams events for the given apikey as a Server-Sent
+// Events feed, for clients that can't or don't want to use WebSockets.
+func handleEventsSSE(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	apiKey := sanitize.BaseName(vars["apikey"])
+	if apiKey == "" {
+		handleError(w, "An API Key must be provided", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleError(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Subscribe before querying the backlog: a subscriber registered after
+	// the query could miss an event published in between. Subscribing
+	// first instead risks the opposite, a live event also appearing in the
+	// backlog, so lastSent drops anything at or below the highest ID
+	// already sent.
+	sub := hub.Subscribe(apiKey)
+	defer hub.Unsubscribe(apiKey, sub)
+
+	since := parseSince(r)
+	backlog, err := replayEvents(apiKey, since)
+	if err != nil {
+		ErrorLogger.Println("Error replaying events", err)
+		return
+	}
+	lastSent := since
+	for _, event := range backlog {
+		if err := writeSSEEvent(w, event); err != nil {
+			return
+		}
+		lastSent = event.ID
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.messages:
+			if !ok {
+				return
+			}
+			if event.ID <= lastSent {
+				continue
+			}
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+			lastSent = event.ID
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSEEvent(w http.ResponseWriter, event eventstore.Event) error {
+	frame, err := marshalEventFrame(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", frame)
+	return err
+}
@@ -0,0 +1,197 @@
+// Package eventstore persists Mailjet webhook events in a SQLite table keyed
+// by apikey and serves the filtered, paginated queries the dashboard needs.
+package eventstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Event is a single persisted Mailjet webhook event.
+type Event struct {
+	ID         int64           `json:"id"`
+	APIKey     string          `json:"api_key"`
+	EventType  string          `json:"event_type"`
+	ReceivedAt int64           `json:"received_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// Filter narrows a Query to a subset of an apikey's events.
+type Filter struct {
+	EventTypes []string
+	Since      int64
+	Limit      int
+	Order      string // "asc" or "desc"; defaults to "desc"
+}
+
+// Store is a SQLite-backed event store. Reads and writes for a given apikey
+// are guarded by a per-apikey sync.RWMutex so one busy tenant can't
+// block another's queries.
+type Store struct {
+	db *sql.DB
+
+	locksMu sync.Mutex
+	locks   map[string]*sync.RWMutex
+}
+
+// Open creates (if needed) and opens the SQLite database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening event store: %w", err)
+	}
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			api_key TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			received_at INTEGER NOT NULL,
+			payload TEXT NOT NULL
+		)
+	`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating events table: %w", err)
+	}
+
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_events_api_key_id ON events (api_key, id)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating events index: %w", err)
+	}
+
+	return &Store{
+		db:    db,
+		locks: make(map[string]*sync.RWMutex),
+	}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) keyLock(apiKey string) *sync.RWMutex {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	lock, ok := s.locks[apiKey]
+	if !ok {
+		lock = &sync.RWMutex{}
+		s.locks[apiKey] = lock
+	}
+
+	return lock
+}
+
+// Append persists a new event for apiKey and returns it with its assigned ID.
+func (s *Store) Append(apiKey, eventType string, payload json.RawMessage) (Event, error) {
+	lock := s.keyLock(apiKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	receivedAt := time.Now().UnixNano()
+	result, err := s.db.Exec(
+		`INSERT INTO events (api_key, event_type, received_at, payload) VALUES (?, ?, ?, ?)`,
+		apiKey, eventType, receivedAt, string(payload),
+	)
+	if err != nil {
+		return Event{}, fmt.Errorf("inserting event: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Event{}, fmt.Errorf("reading inserted event id: %w", err)
+	}
+
+	return Event{
+		ID:         id,
+		APIKey:     apiKey,
+		EventType:  eventType,
+		ReceivedAt: receivedAt,
+		Payload:    payload,
+	}, nil
+}
+
+// Query returns apiKey's events matching filter, newest first unless
+// filter.Order is "asc". A non-positive filter.Limit means unlimited.
+func (s *Store) Query(apiKey string, filter Filter) ([]Event, error) {
+	lock := s.keyLock(apiKey)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	query := strings.Builder{}
+	query.WriteString("SELECT id, api_key, event_type, received_at, payload FROM events WHERE api_key = ?")
+	args := []interface{}{apiKey}
+
+	if len(filter.EventTypes) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(filter.EventTypes)), ",")
+		query.WriteString(fmt.Sprintf(" AND event_type IN (%s)", placeholders))
+		for _, eventType := range filter.EventTypes {
+			args = append(args, eventType)
+		}
+	}
+
+	if filter.Since > 0 {
+		query.WriteString(" AND id > ?")
+		args = append(args, filter.Since)
+	}
+
+	order := "DESC"
+	if strings.EqualFold(filter.Order, "asc") {
+		order = "ASC"
+	}
+	query.WriteString(fmt.Sprintf(" ORDER BY id %s", order))
+
+	if filter.Limit > 0 {
+		query.WriteString(" LIMIT ?")
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var event Event
+		var payload string
+		if err := rows.Scan(&event.ID, &event.APIKey, &event.EventType, &event.ReceivedAt, &payload); err != nil {
+			return nil, fmt.Errorf("scanning event row: %w", err)
+		}
+		event.Payload = json.RawMessage(payload)
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// Prune deletes all but the most recent keep events for apiKey. A
+// non-positive keep is a no-op (unlimited retention).
+func (s *Store) Prune(apiKey string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	lock := s.keyLock(apiKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	_, err := s.db.Exec(`
+		DELETE FROM events
+		WHERE api_key = ? AND id NOT IN (
+			SELECT id FROM events WHERE api_key = ? ORDER BY id DESC LIMIT ?
+		)
+	`, apiKey, apiKey, keep)
+	if err != nil {
+		return fmt.Errorf("pruning events: %w", err)
+	}
+
+	return nil
+}
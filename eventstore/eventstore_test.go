@@ -0,0 +1,160 @@
+package eventstore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	store, err := Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func mustAppend(t *testing.T, store *Store, apiKey, eventType string) Event {
+	t.Helper()
+
+	event, err := store.Append(apiKey, eventType, []byte(`{"event":"`+eventType+`"}`))
+	if err != nil {
+		t.Fatalf("Append(%q, %q) error = %v", apiKey, eventType, err)
+	}
+
+	return event
+}
+
+func TestAppendAssignsIncreasingIDs(t *testing.T) {
+	store := openTestStore(t)
+
+	first := mustAppend(t, store, "key-a", "open")
+	second := mustAppend(t, store, "key-a", "click")
+
+	if second.ID <= first.ID {
+		t.Fatalf("second.ID = %d, want > first.ID = %d", second.ID, first.ID)
+	}
+}
+
+func TestQueryIsScopedToAPIKey(t *testing.T) {
+	store := openTestStore(t)
+
+	mustAppend(t, store, "key-a", "open")
+	mustAppend(t, store, "key-b", "open")
+
+	events, err := store.Query("key-a", Filter{})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("len(events) = %d, want 1", len(events))
+	}
+	if events[0].APIKey != "key-a" {
+		t.Fatalf("events[0].APIKey = %q, want %q", events[0].APIKey, "key-a")
+	}
+}
+
+func TestQueryOrderAndLimit(t *testing.T) {
+	store := openTestStore(t)
+
+	mustAppend(t, store, "key-a", "open")
+	mustAppend(t, store, "key-a", "click")
+	mustAppend(t, store, "key-a", "bounce")
+
+	events, err := store.Query("key-a", Filter{Order: "asc"})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	want := []string{"open", "click", "bounce"}
+	if len(events) != len(want) {
+		t.Fatalf("len(events) = %d, want %d", len(events), len(want))
+	}
+	for i, eventType := range want {
+		if events[i].EventType != eventType {
+			t.Fatalf("events[%d].EventType = %q, want %q", i, events[i].EventType, eventType)
+		}
+	}
+
+	limited, err := store.Query("key-a", Filter{Limit: 2})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(limited) != 2 {
+		t.Fatalf("len(limited) = %d, want 2", len(limited))
+	}
+	// Default order is newest first.
+	if limited[0].EventType != "bounce" {
+		t.Fatalf("limited[0].EventType = %q, want %q", limited[0].EventType, "bounce")
+	}
+}
+
+func TestQueryFiltersByEventTypeAndSince(t *testing.T) {
+	store := openTestStore(t)
+
+	mustAppend(t, store, "key-a", "open")
+	click := mustAppend(t, store, "key-a", "click")
+	mustAppend(t, store, "key-a", "bounce")
+
+	events, err := store.Query("key-a", Filter{EventTypes: []string{"open", "bounce"}})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("len(events) = %d, want 2", len(events))
+	}
+	for _, event := range events {
+		if event.EventType == "click" {
+			t.Fatalf("unexpected click event in filtered results: %+v", event)
+		}
+	}
+
+	since, err := store.Query("key-a", Filter{Since: click.ID, Order: "asc"})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(since) != 1 || since[0].EventType != "bounce" {
+		t.Fatalf("Query(Since=%d) = %+v, want only the bounce event", click.ID, since)
+	}
+}
+
+func TestPruneKeepsOnlyMostRecent(t *testing.T) {
+	store := openTestStore(t)
+
+	mustAppend(t, store, "key-a", "open")
+	mustAppend(t, store, "key-a", "click")
+	last := mustAppend(t, store, "key-a", "bounce")
+
+	if err := store.Prune("key-a", 1); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+
+	events, err := store.Query("key-a", Filter{})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(events) != 1 || events[0].ID != last.ID {
+		t.Fatalf("Query() after Prune() = %+v, want only %+v", events, last)
+	}
+}
+
+func TestPruneNonPositiveKeepIsNoOp(t *testing.T) {
+	store := openTestStore(t)
+
+	mustAppend(t, store, "key-a", "open")
+	mustAppend(t, store, "key-a", "click")
+
+	if err := store.Prune("key-a", 0); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+
+	events, err := store.Query("key-a", Filter{})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("len(events) = %d, want 2", len(events))
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ninefive/mailjet-live-event-dashboard/eventstore"
+)
+
+// heartbeatInterval controls how often ping frames are sent to subscribers
+// so that intermediate proxies don't time out the connection.
+const heartbeatInterval = 30 * time.Second
+
+// subscriber is a single connected dashboard waiting for events on one apikey.
+type subscriber struct {
+	messages chan eventstore.Event
+}
+
+// topic fans out events for a single apikey to all of its subscribers.
+type topic struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]bool
+}
+
+func newTopic() *topic {
+	return &topic{
+		subscribers: make(map[*subscriber]bool),
+	}
+}
+
+func (t *topic) Publish(event eventstore.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for s := range t.subscribers {
+		select {
+		case s.messages <- event:
+		default:
+			// Subscriber is too slow to keep up; drop the event rather than
+			// blocking the publisher.
+		}
+	}
+}
+
+// eventHub tracks one topic per apikey with at least one connected
+// subscriber, creating it lazily on Subscribe and pruning it on the last
+// Unsubscribe so openAPIKeyStores and the topic map don't grow forever.
+type eventHub struct {
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+var hub = &eventHub{
+	topics: make(map[string]*topic),
+}
+
+// Subscribe registers a new subscriber for apiKey, creating its topic if
+// this is the first one.
+func (h *eventHub) Subscribe(apiKey string) *subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.topics[apiKey]
+	if !ok {
+		t = newTopic()
+		h.topics[apiKey] = t
+		openAPIKeyStores.Inc()
+	}
+
+	s := &subscriber{messages: make(chan eventstore.Event, 16)}
+
+	t.mu.Lock()
+	t.subscribers[s] = true
+	t.mu.Unlock()
+
+	connectedSubscribers.Inc()
+
+	return s
+}
+
+// Unsubscribe removes s from apiKey's topic, deleting the topic once its
+// last subscriber leaves.
+func (h *eventHub) Unsubscribe(apiKey string, s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.topics[apiKey]
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	delete(t.subscribers, s)
+	empty := len(t.subscribers) == 0
+	t.mu.Unlock()
+
+	close(s.messages)
+	connectedSubscribers.Dec()
+
+	if empty {
+		delete(h.topics, apiKey)
+		openAPIKeyStores.Dec()
+	}
+}
+
+// Publish fans a newly persisted event out to every subscriber of apiKey,
+// if any. Topics are created by Subscribe, not here, so publishing for an
+// apikey nobody is watching doesn't leak a map entry.
+func (h *eventHub) Publish(apiKey string, event eventstore.Event) {
+	h.mu.Lock()
+	t, ok := h.topics[apiKey]
+	h.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	t.Publish(event)
+}
+
+func marshalEventFrame(event eventstore.Event) ([]byte, error) {
+	return json.Marshal(event)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ninefive/mailjet-live-event-dashboard/eventstore"
+)
+
+func TestTopicPublishFansOutToAllSubscribers(t *testing.T) {
+	topic := newTopic()
+	a := &subscriber{messages: make(chan eventstore.Event, 1)}
+	b := &subscriber{messages: make(chan eventstore.Event, 1)}
+	topic.subscribers[a] = true
+	topic.subscribers[b] = true
+
+	event := eventstore.Event{ID: 1, APIKey: "key-a", EventType: "open"}
+	topic.Publish(event)
+
+	for _, sub := range []*subscriber{a, b} {
+		select {
+		case got := <-sub.messages:
+			if got.ID != event.ID {
+				t.Fatalf("got event ID %d, want %d", got.ID, event.ID)
+			}
+		default:
+			t.Fatal("subscriber did not receive the published event")
+		}
+	}
+}
+
+func TestTopicPublishDropsWhenSubscriberBufferIsFull(t *testing.T) {
+	topic := newTopic()
+	sub := &subscriber{messages: make(chan eventstore.Event, 1)}
+	topic.subscribers[sub] = true
+
+	topic.Publish(eventstore.Event{ID: 1})
+	topic.Publish(eventstore.Event{ID: 2})
+
+	got := <-sub.messages
+	if got.ID != 1 {
+		t.Fatalf("got event ID %d, want 1 (second publish should have been dropped, not blocked)", got.ID)
+	}
+	select {
+	case extra := <-sub.messages:
+		t.Fatalf("unexpected second event delivered: %+v", extra)
+	default:
+	}
+}
+
+func TestHubSubscribeAndUnsubscribePrunesEmptyTopic(t *testing.T) {
+	h := &eventHub{topics: make(map[string]*topic)}
+
+	sub := h.Subscribe("key-a")
+	if _, ok := h.topics["key-a"]; !ok {
+		t.Fatal("Subscribe() did not create a topic for key-a")
+	}
+
+	h.Unsubscribe("key-a", sub)
+	if _, ok := h.topics["key-a"]; ok {
+		t.Fatal("Unsubscribe() left a topic behind after its last subscriber left")
+	}
+}
+
+func TestHubPublishDeliversOnlyToSubscribedAPIKey(t *testing.T) {
+	h := &eventHub{topics: make(map[string]*topic)}
+
+	sub := h.Subscribe("key-a")
+	defer h.Unsubscribe("key-a", sub)
+
+	h.Publish("key-b", eventstore.Event{ID: 1, APIKey: "key-b"})
+	select {
+	case got := <-sub.messages:
+		t.Fatalf("unexpected event delivered to key-a subscriber: %+v", got)
+	default:
+	}
+
+	h.Publish("key-a", eventstore.Event{ID: 2, APIKey: "key-a"})
+	select {
+	case got := <-sub.messages:
+		if got.ID != 2 {
+			t.Fatalf("got event ID %d, want 2", got.ID)
+		}
+	default:
+		t.Fatal("key-a subscriber did not receive its event")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var eventsReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mailjet_events_received_total",
+	Help: "Number of Mailjet webhook events received, by apikey and event type.",
+}, []string{"api_key", "event_type"})
+
+var upstreamLatencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "mailjet_upstream_latency_seconds",
+	Help: "Latency of HTTP calls made to the Mailjet API, by endpoint and response status.",
+}, []string{"endpoint", "status"})
+
+var connectedSubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "mailjet_stream_subscribers",
+	Help: "Number of currently connected WebSocket/SSE event subscribers.",
+})
+
+var openAPIKeyStores = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "mailjet_open_apikey_stores",
+	Help: "Number of apikeys with an active event topic.",
+})
+
+// observeUpstreamCall times an HTTP round trip to the Mailjet API and
+// records it under mailjet_upstream_latency_seconds.
+func observeUpstreamCall(endpoint string, call func() (*http.Response, error)) (*http.Response, error) {
+	start := time.Now()
+	resp, err := call()
+
+	status := "error"
+	if resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamLatencySeconds.WithLabelValues(endpoint, status).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
+
+// handleMetrics serves Prometheus metrics, optionally gated behind a bearer
+// token so it's safe to expose on a public-facing deployment.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if metricsToken := config.GetMetricsToken(); metricsToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token != metricsToken {
+			handleError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	promhttp.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+)
+
+// visitorIdleTimeout is how long a visitor can go without a request before
+// the janitor evicts it, so the rate limiter doesn't leak memory for
+// one-off callers.
+const visitorIdleTimeout = 10 * time.Minute
+
+const janitorInterval = time.Minute
+
+// defaultMaxBodyBytes caps incoming request bodies when the config sets no
+// positive max_body_bytes, to keep a single oversized POST from
+// blowing up the JSON unmarshal path.
+const defaultMaxBodyBytes = 1 << 20 // 1 MiB
+
+type rateLimitConfig struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// visitorStore tracks one rate.Limiter per source (IP or apikey) for a
+// single endpoint, evicting idle visitors in the background.
+type visitorStore struct {
+	mu       sync.Mutex
+	visitors map[string]*visitor
+}
+
+func newVisitorStore() *visitorStore {
+	vs := &visitorStore{
+		visitors: make(map[string]*visitor),
+	}
+
+	go vs.janitor()
+
+	return vs
+}
+
+// limiterFor returns the rate.Limiter for key, creating it from cfg on
+// first use. On every later call it re-applies cfg's RPS/burst to the
+// existing limiter, so a PATCH /config or config-file reload takes effect
+// on a visitor's very next request instead of only on new visitors.
+func (vs *visitorStore) limiterFor(key string, cfg rateLimitConfig) *rate.Limiter {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	v, ok := vs.visitors[key]
+	if !ok {
+		v = &visitor{limiter: rate.NewLimiter(rate.Limit(cfg.RPS), cfg.Burst)}
+		vs.visitors[key] = v
+	} else {
+		v.limiter.SetLimit(rate.Limit(cfg.RPS))
+		v.limiter.SetBurst(cfg.Burst)
+	}
+	v.lastSeen = time.Now()
+
+	return v.limiter
+}
+
+func (vs *visitorStore) janitor() {
+	for range time.Tick(janitorInterval) {
+		vs.mu.Lock()
+		for key, v := range vs.visitors {
+			if time.Since(v.lastSeen) > visitorIdleTimeout {
+				delete(vs.visitors, key)
+			}
+		}
+		vs.mu.Unlock()
+	}
+}
+
+// rateLimitStores holds one visitorStore per configured endpoint name,
+// created lazily on first use of that endpoint's middleware.
+var rateLimitStores = struct {
+	mu    sync.Mutex
+	byKey map[string]*visitorStore
+}{byKey: make(map[string]*visitorStore)}
+
+func storeFor(endpoint string) *visitorStore {
+	rateLimitStores.mu.Lock()
+	defer rateLimitStores.mu.Unlock()
+
+	vs, ok := rateLimitStores.byKey[endpoint]
+	if !ok {
+		vs = newVisitorStore()
+		rateLimitStores.byKey[endpoint] = vs
+	}
+
+	return vs
+}
+
+// visitorKey identifies the caller a request should be rate limited as: the
+// path's apikey for ingest endpoints (handleEvents has no Basic Auth of its
+// own, so without this every tenant behind the same source IP would share
+// one bucket), the Basic Auth username otherwise, falling back to the
+// source IP.
+func visitorKey(r *http.Request) string {
+	if apiKey := mux.Vars(r)["apikey"]; apiKey != "" {
+		return "key:" + apiKey
+	}
+
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		return "user:" + username
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// rateLimit wraps next with a per-visitor rate.Limiter configured under
+// endpoint in mailjetConfig.RateLimits. Endpoints with no configured limit,
+// or a non-positive RPS, are left unlimited.
+func rateLimit(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg, ok := config.RateLimit(endpoint)
+		if !ok || cfg.RPS <= 0 {
+			next(w, r)
+			return
+		}
+
+		vs := storeFor(endpoint)
+		limiter := vs.limiterFor(visitorKey(r), cfg)
+		if !limiter.Allow() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/cfg.RPS))))
+			handleError(w, fmt.Sprintf("Rate limit exceeded for %s", endpoint), http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// limitBody caps the size of the request body next is allowed to read, so
+// a single oversized webhook POST can't exhaust memory in the JSON
+// unmarshal path. The cap is read from config on every request, so a
+// PATCH /config or config-file reload of max_body_bytes takes effect
+// immediately instead of only at startup.
+func limitBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		maxBytes := config.GetMaxBodyBytes()
+		if maxBytes <= 0 {
+			maxBytes = defaultMaxBodyBytes
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next(w, r)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	TraceLogger = log.New(io.Discard, "", 0)
+	ErrorLogger = log.New(io.Discard, "", 0)
+}
+
+func TestVisitorKeyPrefersPathAPIKey(t *testing.T) {
+	r := httptest.NewRequest("POST", "/apikey/abc123/events", nil)
+	r = mux.SetURLVars(r, map[string]string{"apikey": "abc123"})
+	r.SetBasicAuth("some-user", "secret")
+
+	if got, want := visitorKey(r), "key:abc123"; got != want {
+		t.Fatalf("visitorKey() = %q, want %q", got, want)
+	}
+}
+
+func TestVisitorKeyFallsBackToBasicAuth(t *testing.T) {
+	r := httptest.NewRequest("POST", "/messages", nil)
+	r.SetBasicAuth("some-user", "secret")
+
+	if got, want := visitorKey(r), "user:some-user"; got != want {
+		t.Fatalf("visitorKey() = %q, want %q", got, want)
+	}
+}
+
+func TestVisitorKeyFallsBackToSourceIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/messages", nil)
+	r.RemoteAddr = "203.0.113.5:54321"
+
+	if got, want := visitorKey(r), "ip:203.0.113.5"; got != want {
+		t.Fatalf("visitorKey() = %q, want %q", got, want)
+	}
+}
+
+func TestLimiterForUpdatesLiveOnConfigChange(t *testing.T) {
+	vs := newVisitorStore()
+
+	limiter := vs.limiterFor("key:abc", rateLimitConfig{RPS: 1, Burst: 1})
+	if limiter.Burst() != 1 {
+		t.Fatalf("Burst() = %d, want 1", limiter.Burst())
+	}
+
+	reloaded := vs.limiterFor("key:abc", rateLimitConfig{RPS: 5, Burst: 10})
+	if reloaded != limiter {
+		t.Fatal("limiterFor() returned a different *rate.Limiter for the same key")
+	}
+	if reloaded.Burst() != 10 {
+		t.Fatalf("Burst() after reload = %d, want 10", reloaded.Burst())
+	}
+}
+
+func TestRateLimitRetryAfterHandlesSubOneRPSWithoutPanicking(t *testing.T) {
+	config = mailjetConfig{RateLimits: map[string]rateLimitConfig{
+		"test_endpoint": {RPS: 0.5, Burst: 1},
+	}}
+
+	handler := rateLimit("test_endpoint", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r := httptest.NewRequest("POST", "/messages", nil)
+	r.RemoteAddr = "203.0.113.9:1234"
+
+	first := httptest.NewRecorder()
+	handler(first, r)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want %d", first.Code, http.StatusOK)
+	}
+
+	second := httptest.NewRecorder()
+	handler(second, r)
+	if second.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want %d", second.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter := second.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Fatalf("Retry-After = %q, want %q", retryAfter, "2")
+	}
+}
@@ -7,27 +7,22 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/kennygrant/sanitize"
+	"github.com/ninefive/mailjet-live-event-dashboard/eventstore"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"sync"
 	"strconv"
+	"strings"
+	"sync"
 )
 
-type eventPayload interface{}
-
 type mailjetAPIEvent struct {
 	Event string `json:"event"`
 }
 
-type eventItem struct {
-	EventType string
-	Payload   eventPayload
-}
-
 type messagePayload struct {
 	FromEmail string
 	Recipient string
@@ -53,9 +48,26 @@ type mailjetAPIEventCallbackUrlPayload struct {
 }
 
 type mailjetConfig struct {
-	BaseUrl        string            `json:"base_url"`
-	MaxEventsCount int               `json:"max_events_count"`
-	Default        map[string]string `json:"default"`
+	// mu guards mutations so concurrent PATCH /config and config-file
+	// reloads can't interleave and lose an update.
+	mu sync.RWMutex
+
+	BaseUrl          string                     `json:"base_url"`
+	EventStorePath   string                     `json:"event_store_path"`
+	DefaultRetention int                        `json:"default_retention"`
+	Retention        map[string]int             `json:"retention"`
+	RateLimits       map[string]rateLimitConfig `json:"rate_limits"`
+	MaxBodyBytes     int64                      `json:"max_body_bytes"`
+	Smtp             smtpConfig                 `json:"smtp"`
+	MetricsToken     string                     `json:"metrics_token"`
+	Default          map[string]string          `json:"default"`
+}
+
+type smtpConfig struct {
+	ListenAddr      string `json:"listen_addr"`
+	TLSCertFile     string `json:"tls_cert_file"`
+	TLSKeyFile      string `json:"tls_key_file"`
+	MaxMessageBytes int64  `json:"max_message_bytes"`
 }
 
 type apiError struct {
@@ -64,14 +76,20 @@ type apiError struct {
 
 const defaultAddr = "127.0.0.1"
 const defaultPort = 3000
-const dataFileBaseName = "events_%s.json"
+const defaultEventStorePath = "./events.db"
 const defaultConfigFilePath = "./config.json"
 const eventCallbackUrlBaseUrl = "/v3/REST/eventcallbackurl"
 
-var eventMutex = new(sync.Mutex)
-
 var config = mailjetConfig{}
 
+var store *eventstore.Store
+
+// retentionFor returns how many events to keep for apiKey: a per-key
+// override if configured, otherwise the config-wide default (0 = unlimited).
+func retentionFor(apiKey string) int {
+	return config.RetentionFor(apiKey)
+}
+
 var TraceLogger *log.Logger
 var ErrorLogger *log.Logger
 
@@ -86,33 +104,29 @@ func handleError(w http.ResponseWriter, message string, status int) {
 
 func handleAuth(r *http.Request) (string, string, error) {
 	username, password, ok := r.BasicAuth()
-	var err string
 	if !ok {
-		err = "Error when reading auth"
+		return username, password, errors.New("Error when reading auth")
 	}
 
+	return username, password, validateCredentials(username, password)
+}
+
+// validateCredentials checks that an apikey/secret pair is present, however
+// it was transported (HTTP basic auth, SMTP AUTH PLAIN, ...).
+func validateCredentials(username, password string) error {
 	if username == "" {
-		err = "API key is mandatory"
+		return errors.New("API key is mandatory")
 	}
 
 	if password == "" {
-		err = "API secret is mandatory"
-	}
-
-	if err != "" {
-		return username, password, errors.New(err)
+		return errors.New("API secret is mandatory")
 	}
 
-	return username, password, nil
+	return nil
 }
 
 // Handle events
 func handleEvents(w http.ResponseWriter, r *http.Request) {
-	// Since multiple requests could come in at once, ensure we have a lock
-	// around all file operations
-	eventMutex.Lock()
-	defer eventMutex.Unlock()
-
 	vars := mux.Vars(r)
 	apiKey := sanitize.BaseName(vars["apikey"])
 	if apiKey == "" {
@@ -120,73 +134,35 @@ func handleEvents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dataFileSession := fmt.Sprintf(dataFileBaseName, apiKey)
-
-	// Stat the file, so we can find its current permissions
-	var fi os.FileInfo
-	var errStat error
-	fi, errStat = os.Stat(dataFileSession)
-	if errStat != nil {
-		f, err := os.Create(dataFileSession)
-		if err != nil {
-			handleError(w, "Error when creating session file", http.StatusInternalServerError)
-		}
-
-		fi, _ = f.Stat()
-		f.WriteString("[]")
-		f.Close()
-	}
-
-	// Read the events from the file.
-	eventData, err := ioutil.ReadFile(dataFileSession)
-	if err != nil {
-		handleError(w, fmt.Sprintf("Unable to read the data file (%s): %s", dataFileSession, err), http.StatusInternalServerError)
-		return
-	}
-
 	switch r.Method {
 	case "POST":
-		// Decode the JSON data
-		events := make([]eventItem, 0)
-		if err := json.Unmarshal(eventData, &events); err != nil {
-			handleError(w, fmt.Sprintf("Unable to Unmarshal events from data file (%s): %s", dataFileSession, err), http.StatusInternalServerError)
-			return
-		}
-
 		response, _ := ioutil.ReadAll(r.Body)
 		TraceLogger.Println("New event payload received", string(response))
 
-		// Add a new event to the in memory slice of events
 		var mjEvent mailjetAPIEvent
-		err1 := json.Unmarshal(response, &mjEvent)
-		if err1 != nil {
-			handleError(w, err1.Error(), http.StatusBadRequest)
+		if err := json.Unmarshal(response, &mjEvent); err != nil {
+			handleError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		var mjEventPayload eventPayload
-		json.Unmarshal(response, &mjEventPayload)
-		newEventItem := eventItem{
-			EventType: mjEvent.Event,
-			Payload:   mjEventPayload,
-		}
-		events = append([]eventItem{newEventItem}, events...)
-		if config.MaxEventsCount > 0 && len(events) > config.MaxEventsCount {
-			events = events[:config.MaxEventsCount]
+		event, err := store.Append(apiKey, mjEvent.Event, json.RawMessage(response))
+		if err != nil {
+			handleError(w, fmt.Sprintf("Unable to persist event: %s", err), http.StatusInternalServerError)
+			return
 		}
 
-		// Marshal the events to indented json.
-		var err3 error
-		eventData, err3 = json.Marshal(events)
-		if err3 != nil {
-			handleError(w, fmt.Sprintf("Unable to marshal events to json: %s", err3), http.StatusInternalServerError)
-			return
+		if keep := retentionFor(apiKey); keep > 0 {
+			if err := store.Prune(apiKey, keep); err != nil {
+				ErrorLogger.Println("Error pruning events", err)
+			}
 		}
 
-		// Write out the events to the file, preserving permissions
-		err2 := ioutil.WriteFile(dataFileSession, eventData, fi.Mode())
-		if err2 != nil {
-			handleError(w, fmt.Sprintf("Unable to write events to data file (%s): %s", dataFileSession, err3), http.StatusInternalServerError)
+		eventsReceivedTotal.WithLabelValues(apiKey, event.EventType).Inc()
+		hub.Publish(apiKey, event)
+
+		eventData, err := json.Marshal(event)
+		if err != nil {
+			handleError(w, fmt.Sprintf("Unable to marshal event to json: %s", err), http.StatusInternalServerError)
 			return
 		}
 
@@ -195,9 +171,32 @@ func handleEvents(w http.ResponseWriter, r *http.Request) {
 		io.Copy(w, bytes.NewReader(eventData))
 
 	case "GET":
+		filter := eventstore.Filter{
+			Since: parseSince(r),
+			Order: r.URL.Query().Get("order"),
+			Limit: 100,
+		}
+		if eventTypes := r.URL.Query().Get("event"); eventTypes != "" {
+			filter.EventTypes = strings.Split(eventTypes, ",")
+		}
+		if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+			filter.Limit = limit
+		}
+
+		events, err := store.Query(apiKey, filter)
+		if err != nil {
+			handleError(w, fmt.Sprintf("Unable to query events: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		eventData, err := json.Marshal(events)
+		if err != nil {
+			handleError(w, fmt.Sprintf("Unable to marshal events to json: %s", err), http.StatusInternalServerError)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Cache-Control", "no-cache")
-		// stream the contents of the file to the response
 		io.Copy(w, bytes.NewReader(eventData))
 
 	default:
@@ -206,6 +205,57 @@ func handleEvents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// validateMessagePayload defaults Recipient to FromEmail and checks the
+// fields mandatory for every way a message can be submitted (HTTP POST,
+// SMTP, ...).
+func validateMessagePayload(payload *messagePayload) error {
+	if payload.FromEmail == "" {
+		return errors.New("FromEmail is mandatory")
+	}
+
+	if payload.Recipient == "" {
+		payload.Recipient = payload.FromEmail
+	}
+
+	if payload.Subject == "" {
+		return errors.New("Subject is mandatory")
+	}
+
+	if payload.Body == "" {
+		return errors.New("Body is mandatory")
+	}
+
+	return nil
+}
+
+// sendMailjetMessage POSTs payload to the Mailjet Send API using username
+// and password as basic auth. This is the code path handleMessages and the
+// SMTP bridge both forward through.
+func sendMailjetMessage(username, password string, payload messagePayload) (*http.Response, error) {
+	mjPayload := mailjetAPIMessagePayload{
+		FromEmail: payload.FromEmail,
+		To:        payload.Recipient,
+		Subject:   payload.Subject,
+		Body:      payload.Body,
+	}
+	payloadMarshalled, err := json.Marshal(mjPayload)
+	if err != nil {
+		return nil, fmt.Errorf("Error when marshalling payload : %s", err)
+	}
+
+	client := &http.Client{}
+	req, err := http.NewRequest("POST", config.GetBaseUrl()+"/v3/send/message", bytes.NewReader(payloadMarshalled))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(username, password)
+
+	return observeUpstreamCall("send_message", func() (*http.Response, error) {
+		return client.Do(req)
+	})
+}
+
 // Handle messages
 func handleMessages(w http.ResponseWriter, r *http.Request) {
 
@@ -214,9 +264,8 @@ func handleMessages(w http.ResponseWriter, r *http.Request) {
 		reqBody, _ := ioutil.ReadAll(r.Body)
 		TraceLogger.Println("New message payload received", string(reqBody))
 
-		messagePayload := messagePayload{}
-		err := json.Unmarshal(reqBody, &messagePayload)
-		if err != nil {
+		payload := messagePayload{}
+		if err := json.Unmarshal(reqBody, &payload); err != nil {
 			handleError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -227,51 +276,20 @@ func handleMessages(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if messagePayload.FromEmail == "" {
-			handleError(w, "FromEmail is mandatory", http.StatusBadRequest)
-			return
-		}
-
-		if messagePayload.Recipient == "" {
-			messagePayload.Recipient = messagePayload.FromEmail
-		}
-
-		if messagePayload.Subject == "" {
-			handleError(w, "Subject is mandatory", http.StatusBadRequest)
-			return
-		}
-
-		if messagePayload.Body == "" {
-			handleError(w, "Body is mandatory", http.StatusBadRequest)
+		if err := validateMessagePayload(&payload); err != nil {
+			handleError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		payload := mailjetAPIMessagePayload{
-			FromEmail: messagePayload.FromEmail,
-			To: messagePayload.Recipient,
-			Subject:   messagePayload.Subject,
-			Body:      messagePayload.Body,
-		}
-		payloadMarshalled, err := json.Marshal(payload)
+		mailjetResponse, err := sendMailjetMessage(username, password, payload)
 		if err != nil {
-			handleError(w, fmt.Sprintf("Error when marshalling payload : %s", err), http.StatusInternalServerError)
+			handleError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		client := &http.Client{}
-		req, _ := http.NewRequest("POST", config.BaseUrl+"/v3/send/message", bytes.NewReader(payloadMarshalled))
-		req.Header.Set("Content-Type", "application/json")
-		req.SetBasicAuth(username, password)
-
-		mailjetResponse, err := client.Do(req)
 		if mailjetResponse.StatusCode != 200 {
 			handleError(w, mailjetResponse.Status, mailjetResponse.StatusCode)
 			return
 		}
-		if err != nil {
-			handleError(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
 		TraceLogger.Println("Payload POST-ed to Mailjet Send API", mailjetResponse)
 
 		w.Header().Set("Content-Type", "application/json")
@@ -327,7 +345,7 @@ func handleEventSetup(w http.ResponseWriter, r *http.Request) {
 
 		client := &http.Client{}
 
-		baseEventUrl, _ := url.Parse(fmt.Sprintf("%s/%s", config.BaseUrl, eventCallbackUrlBaseUrl))
+		baseEventUrl, _ := url.Parse(fmt.Sprintf("%s/%s", config.GetBaseUrl(), eventCallbackUrlBaseUrl))
 		eventUrl, err := url.Parse(fmt.Sprintf("%s/%s", baseEventUrl, fmt.Sprintf("%s|%t", p.EventType, false)))
 		if err != nil {
 			TraceLogger.Println("Error while building event url", err)
@@ -337,7 +355,9 @@ func handleEventSetup(w http.ResponseWriter, r *http.Request) {
 
 		getReq, _ := http.NewRequest("GET", eventUrl.String(), nil)
 		getReq.SetBasicAuth(username, password)
-		getResponse, err := client.Do(getReq)
+		getResponse, err := observeUpstreamCall("event_setup_get", func() (*http.Response, error) {
+			return client.Do(getReq)
+		})
 		if err != nil {
 			handleError(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -351,7 +371,9 @@ func handleEventSetup(w http.ResponseWriter, r *http.Request) {
 			postReq, _ := http.NewRequest("POST", baseEventUrl.String(), bytes.NewReader(payloadMarshalled))
 			postReq.Header.Set("Content-Type", "application/json")
 			postReq.SetBasicAuth(username, password)
-			postResponse, err := client.Do(postReq)
+			postResponse, err := observeUpstreamCall("event_setup_post", func() (*http.Response, error) {
+				return client.Do(postReq)
+			})
 
 			TraceLogger.Println("Mailjet API POST response to", baseEventUrl.String(), postResponse)
 			if err != nil {
@@ -366,7 +388,9 @@ func handleEventSetup(w http.ResponseWriter, r *http.Request) {
 			putReq, _ := http.NewRequest("PUT", eventUrl.String(), bytes.NewReader(payloadMarshalled))
 			putReq.SetBasicAuth(username, password)
 			putReq.Header.Set("Content-Type", "application/json")
-			putResponse, err := client.Do(putReq)
+			putResponse, err := observeUpstreamCall("event_setup_put", func() (*http.Response, error) {
+				return client.Do(putReq)
+			})
 
 			TraceLogger.Println("Mailjet API PUT response to", eventUrl, putResponse)
 			if err != nil {
@@ -388,14 +412,52 @@ func handleEventSetup(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type configPatch struct {
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
 // Handle messages
 func handleConfig(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
+		fingerprint, _ := config.Fingerprint()
 		configJson, _ := json.Marshal(&config)
 
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", fingerprint)
 		io.Copy(w, bytes.NewReader(configJson))
+
+	case "PATCH":
+		reqBody, _ := ioutil.ReadAll(r.Body)
+
+		var patch configPatch
+		if err := json.Unmarshal(reqBody, &patch); err != nil {
+			handleError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := config.DoLockedAction(r.Header.Get("If-Match"), func(c *mailjetConfig) error {
+			return c.applyPatch(patch.Path, patch.Value)
+		})
+		if errors.Is(err, ErrFingerprintMismatch) {
+			handleError(w, "Config has changed since the If-Match fingerprint was read", http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			handleError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		TraceLogger.Println("Config patched", patch.Path)
+
+		fingerprint, _ := config.Fingerprint()
+		configJson, _ := json.Marshal(&config)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", fingerprint)
+		io.Copy(w, bytes.NewReader(configJson))
+
 	default:
 		// Don't know the method, so error
 		handleError(w, fmt.Sprintf("Unsupported method: %s", r.Method), http.StatusMethodNotAllowed)
@@ -450,13 +512,29 @@ func main() {
 		return
 	}
 	json.Unmarshal(configFile, &config)
-	TraceLogger.Println(fmt.Sprintf("Read config %s: %+v", configFilePath, config))
+	TraceLogger.Println(fmt.Sprintf("Read config %s: %+v", configFilePath, &config))
+
+	eventStorePath := config.EventStorePath
+	if eventStorePath == "" {
+		eventStorePath = defaultEventStorePath
+	}
+	store, err = eventstore.Open(eventStorePath)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Unable to open the event store (%s): %s", eventStorePath, err))
+	}
+	defer store.Close()
+
+	go startSMTPServer(config.Smtp)
+	go watchConfig(configFilePath)
 
 	r := mux.NewRouter()
 	r.HandleFunc("/config", handleConfig)
-	r.HandleFunc("/apikey/{apikey}/events", handleEvents)
-	r.HandleFunc("/apikey/{apikey}/events/setup", handleEventSetup)
-	r.HandleFunc("/messages", handleMessages)
+	r.HandleFunc("/metrics", handleMetrics)
+	r.HandleFunc("/apikey/{apikey}/events", rateLimit("events_post", limitBody(handleEvents)))
+	r.HandleFunc("/apikey/{apikey}/events/ws", handleEventsWS)
+	r.HandleFunc("/apikey/{apikey}/events/sse", handleEventsSSE)
+	r.HandleFunc("/apikey/{apikey}/events/setup", rateLimit("events_setup_post", limitBody(handleEventSetup)))
+	r.HandleFunc("/messages", rateLimit("messages_post", limitBody(handleMessages)))
 
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./public")))
 	http.Handle("/", r)
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/mail"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultSMTPListenAddr = ":2525"
+const defaultSMTPMaxMessageBytes = 1 << 20 // 1 MiB
+
+var smtpMailsAccepted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "mailjet_smtp_accepted_total",
+	Help: "Number of inbound SMTP messages successfully forwarded to the Mailjet Send API.",
+})
+
+var smtpMailsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mailjet_smtp_rejected_total",
+	Help: "Number of inbound SMTP messages rejected, by reason.",
+}, []string{"reason"})
+
+// mailjetSMTPBackend authenticates incoming SMTP connections against the
+// Mailjet API key/secret and forwards accepted mail through the same
+// Mailjet Send API call handleMessages uses.
+type mailjetSMTPBackend struct{}
+
+func (b *mailjetSMTPBackend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &mailjetSMTPSession{}, nil
+}
+
+type mailjetSMTPSession struct {
+	username string
+	password string
+	from     string
+}
+
+func (s *mailjetSMTPSession) AuthPlain(username, password string) error {
+	if err := validateCredentials(username, password); err != nil {
+		smtpMailsRejected.WithLabelValues("auth").Inc()
+		return err
+	}
+
+	s.username = username
+	s.password = password
+	return nil
+}
+
+func (s *mailjetSMTPSession) AuthMechanisms() []string {
+	return []string{sasl.Plain}
+}
+
+func (s *mailjetSMTPSession) Auth(mech string) (sasl.Server, error) {
+	return sasl.NewPlainServer(func(identity, username, password string) error {
+		return s.AuthPlain(username, password)
+	}), nil
+}
+
+func (s *mailjetSMTPSession) Mail(from string, opts *smtp.MailOptions) error {
+	s.from = from
+	return nil
+}
+
+func (s *mailjetSMTPSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	return nil
+}
+
+func (s *mailjetSMTPSession) Data(r io.Reader) error {
+	if s.username == "" {
+		smtpMailsRejected.WithLabelValues("auth").Inc()
+		return errors.New("SMTP AUTH is required before sending a message")
+	}
+
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		smtpMailsRejected.WithLabelValues("read").Inc()
+		return err
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		smtpMailsRejected.WithLabelValues("parse").Inc()
+		return fmt.Errorf("parsing message: %w", err)
+	}
+
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		smtpMailsRejected.WithLabelValues("read").Inc()
+		return err
+	}
+
+	payload := messagePayload{
+		FromEmail: addressOf(msg.Header.Get("From"), s.from),
+		Recipient: addressOf(msg.Header.Get("To"), ""),
+		Subject:   msg.Header.Get("Subject"),
+		Body:      string(body),
+	}
+
+	if err := validateMessagePayload(&payload); err != nil {
+		smtpMailsRejected.WithLabelValues("validation").Inc()
+		return err
+	}
+
+	mailjetResponse, err := sendMailjetMessage(s.username, s.password, payload)
+	if err != nil {
+		smtpMailsRejected.WithLabelValues("upstream").Inc()
+		return err
+	}
+	if mailjetResponse.StatusCode != 200 {
+		smtpMailsRejected.WithLabelValues("upstream").Inc()
+		return fmt.Errorf("Mailjet Send API returned %s", mailjetResponse.Status)
+	}
+
+	smtpMailsAccepted.Inc()
+	TraceLogger.Println("SMTP message forwarded to Mailjet Send API", payload.FromEmail, payload.Recipient)
+
+	return nil
+}
+
+func (s *mailjetSMTPSession) Reset() {}
+
+func (s *mailjetSMTPSession) Logout() error {
+	return nil
+}
+
+// addressOf extracts the email address out of an RFC5322 header value such
+// as "Jane Doe <jane@example.com>", falling back to fallback if header is
+// empty or unparseable.
+func addressOf(header, fallback string) string {
+	if header == "" {
+		return fallback
+	}
+
+	addr, err := mail.ParseAddress(header)
+	if err != nil {
+		return fallback
+	}
+
+	return addr.Address
+}
+
+// startSMTPServer runs the optional SMTP ingest bridge, blocking until it
+// exits. It is started in its own goroutine by main and never returns under
+// normal operation. It returns at once, starting nothing, when the config's
+// smtp block is absent or empty.
+func startSMTPServer(cfg smtpConfig) {
+	if cfg == (smtpConfig{}) {
+		return
+	}
+
+	listenAddr := cfg.ListenAddr
+	if listenAddr == "" {
+		listenAddr = defaultSMTPListenAddr
+	}
+
+	maxMessageBytes := cfg.MaxMessageBytes
+	if maxMessageBytes == 0 {
+		maxMessageBytes = defaultSMTPMaxMessageBytes
+	}
+
+	s := smtp.NewServer(&mailjetSMTPBackend{})
+	s.Addr = listenAddr
+	s.Domain = "mailjet-live-event-dashboard"
+	s.MaxMessageBytes = maxMessageBytes
+	s.AllowInsecureAuth = cfg.TLSCertFile == ""
+
+	if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			ErrorLogger.Println("Unable to load SMTP TLS certificate", err)
+		} else {
+			s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+	}
+
+	TraceLogger.Println(fmt.Sprintf("SMTP bridge listening on %s", listenAddr))
+	if err := s.ListenAndServe(); err != nil {
+		ErrorLogger.Println("SMTP server stopped", err)
+	}
+}
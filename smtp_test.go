@@ -0,0 +1,15 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDataRejectsUnauthenticatedSession(t *testing.T) {
+	s := &mailjetSMTPSession{}
+
+	err := s.Data(strings.NewReader("Subject: test\r\n\r\nbody\r\n"))
+	if err == nil {
+		t.Fatal("Data() error = nil, want an error for a session without AUTH")
+	}
+}